@@ -0,0 +1,134 @@
+package yamux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// protoVersion is the only version we support
+const (
+	protoVersion uint8 = 0
+)
+
+const (
+	// typeData is used for data frames. They are followed
+	// by length bytes worth of payload.
+	typeData uint8 = iota
+
+	// typeWindowUpdate is used to change the window of
+	// a given stream. The length indicates the delta
+	// update to the window.
+	typeWindowUpdate
+
+	// typePing is sent as a keep-alive or to measure
+	// the RTT. The StreamID and Length value are echoed
+	// back in the response.
+	typePing
+
+	// typeGoAway is sent to terminate a session. The
+	// StreamID should be 0 and the length is an error
+	// code.
+	typeGoAway
+
+	// typeConsumed is sent by a stream's receiver in place of
+	// typeWindowUpdate once consumption-based flow control has been
+	// negotiated via flagConsumedMode. Unlike typeWindowUpdate's delta,
+	// its Length names an 8-byte body (two big-endian uint32 fields:
+	// cumulative bytes consumed, then the receiver's window) appended
+	// after the header, so the sender can derive remaining credit from
+	// absolute counters rather than a running sum of deltas.
+	typeConsumed
+)
+
+const (
+	// flagSYN is sent to signal a new stream. May
+	// be sent with a data payload
+	flagSYN uint16 = 1 << iota
+
+	// flagACK is sent to acknowledge a new stream. May
+	// be sent with a data payload
+	flagACK
+
+	// flagFIN is sent to half-close the given stream.
+	// May be sent with a data payload.
+	flagFIN
+
+	// flagRST is used to hard close a given stream.
+	flagRST
+
+	// flagConsumedMode is carried on a stream's SYN to propose
+	// consumption-based window updates, and echoed back on the ACK to
+	// confirm them once the receiver's Config agrees. A peer that
+	// doesn't understand the bit simply ignores it, so proposing it is
+	// safe against an older implementation; the stream just keeps using
+	// the default delta-based typeWindowUpdate.
+	flagConsumedMode
+)
+
+const (
+	// initialStreamWindow is the initial stream window size.
+	initialStreamWindow uint32 = 256 * 1024
+)
+
+const (
+	sizeOfVersion  = 1
+	sizeOfType     = 1
+	sizeOfFlags    = 2
+	sizeOfStreamID = 4
+	sizeOfLength   = 4
+	headerSize     = sizeOfVersion + sizeOfType + sizeOfFlags +
+		sizeOfStreamID + sizeOfLength
+
+	// sizeOfConsumed is the size, in bytes, of a typeConsumed frame's
+	// body: two big-endian uint32 counters, consumed bytes and window.
+	sizeOfConsumed = 8
+)
+
+// header is used to encode/decode the yamux frame header
+type header []byte
+
+func (h header) Version() uint8 {
+	return h[0]
+}
+
+func (h header) MsgType() uint8 {
+	return h[1]
+}
+
+func (h header) Flags() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+func (h header) StreamID() uint32 {
+	return binary.BigEndian.Uint32(h[4:8])
+}
+
+func (h header) Length() uint32 {
+	return binary.BigEndian.Uint32(h[8:12])
+}
+
+func (h header) String() string {
+	return fmt.Sprintf("Vsn:%d Type:%d Flags:%d StreamID:%d Length:%d",
+		h.Version(), h.MsgType(), h.Flags(), h.StreamID(), h.Length())
+}
+
+func (h header) encode(msgType uint8, flags uint16, streamID uint32, length uint32) {
+	h[0] = protoVersion
+	h[1] = msgType
+	binary.BigEndian.PutUint16(h[2:4], flags)
+	binary.BigEndian.PutUint32(h[4:8], streamID)
+	binary.BigEndian.PutUint32(h[8:12], length)
+}
+
+// encodeConsumed serializes a typeConsumed frame's body.
+func encodeConsumed(consumed, window uint32) []byte {
+	body := make([]byte, sizeOfConsumed)
+	binary.BigEndian.PutUint32(body[0:4], consumed)
+	binary.BigEndian.PutUint32(body[4:8], window)
+	return body
+}
+
+// decodeConsumed parses a typeConsumed frame's body.
+func decodeConsumed(body []byte) (consumed, window uint32) {
+	return binary.BigEndian.Uint32(body[0:4]), binary.BigEndian.Uint32(body[4:8])
+}
@@ -0,0 +1,60 @@
+package yamux
+
+import (
+	"fmt"
+)
+
+var (
+	// ErrInvalidVersion means we received a frame with an
+	// invalid version
+	ErrInvalidVersion = fmt.Errorf("invalid protocol version")
+
+	// ErrInvalidMsgType means we received a frame with an
+	// invalid message type
+	ErrInvalidMsgType = fmt.Errorf("invalid msg type")
+
+	// ErrSessionShutdown is used if there is a shutdown during
+	// an operation
+	ErrSessionShutdown = fmt.Errorf("session shutdown")
+
+	// ErrStreamsExceeded is returned if we have too
+	// many streams in flight
+	ErrStreamsExceeded = fmt.Errorf("streams exceeded")
+
+	// ErrDuplicateStream is used if a duplicate stream is
+	// opened inbound
+	ErrDuplicateStream = fmt.Errorf("duplicate stream")
+
+	// ErrRecvWindowExceeded indicates the window was exceeded
+	ErrRecvWindowExceeded = fmt.Errorf("recv window exceeded")
+
+	// ErrTimeout is used when we reach an IO deadline
+	ErrTimeout = fmt.Errorf("i/o deadline reached")
+
+	// ErrStreamClosed is returned when a stream is already
+	// closed and we attempt to write
+	ErrStreamClosed = fmt.Errorf("stream closed")
+
+	// ErrUnexpectedFlag is returned when we get an unexpected flag
+	ErrUnexpectedFlag = fmt.Errorf("unexpected flag")
+
+	// ErrRemoteGoAway is returned when the remote peer is not
+	// accepting new connections
+	ErrRemoteGoAway = fmt.Errorf("remote end is not accepting connections")
+
+	// ErrConnectionReset is returned when the stream is reset
+	ErrConnectionReset = fmt.Errorf("connection reset")
+
+	// ErrConnectionWriteTimeout is returned when we are unable to
+	// write to the underlying connection within the configured
+	// ConnectionWriteTimeout
+	ErrConnectionWriteTimeout = fmt.Errorf("connection write timeout")
+
+	// ErrKeepAliveTimeout is sent if a keepalive ping fails to
+	// receive a pong within KeepAliveTimeout.
+	ErrKeepAliveTimeout = fmt.Errorf("keepalive timeout")
+
+	// ErrInvalidConsumedFrame is returned when a typeConsumed frame's
+	// Length is anything other than the size of its two uint32 counters
+	ErrInvalidConsumedFrame = fmt.Errorf("invalid consumed frame")
+)
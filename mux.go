@@ -0,0 +1,141 @@
+// Package yamux is used to multiplex multiple logical streams
+// over a single underlying transport connection.
+package yamux
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Config is used to tune the Yamux session
+type Config struct {
+	// AcceptBacklog is used to limit how many streams may be
+	// waiting an accept.
+	AcceptBacklog int
+
+	// EnableKeepAlive is used to do a period ping to
+	// keep the connection alive.
+	EnableKeepAlive bool
+
+	// KeepAliveInterval is how often to perform the keep alive
+	KeepAliveInterval time.Duration
+
+	// KeepAliveTimeout is how long a keepalive ping may go unanswered
+	// before the session gives up on the peer and tears itself down.
+	KeepAliveTimeout time.Duration
+
+	// ConnectionWriteTimeout is meant to be a "safety valve" timeout
+	// after we which will suspect a problem with the underlying
+	// connection and close it. This is only applied to writes, where
+	//'s it seen as a non-blocking operation.
+	ConnectionWriteTimeout time.Duration
+
+	// MaxStreamWindowSize is used to control the maximum
+	// window size that we allow for a stream.
+	MaxStreamWindowSize uint32
+
+	// MaxReceiveBuffer bounds the aggregate number of bytes a session
+	// may have buffered awaiting Read across all of its streams,
+	// independent of any single stream's window. This protects
+	// against a peer that opens many streams and stops reading,
+	// which would otherwise be free to buffer without limit even
+	// though each stream stays within its own window. Zero disables
+	// the cap. When set, it must be at least MaxStreamWindowSize, or a
+	// single full-size data frame could never fit and recvLoop would
+	// block on it forever; VerifyConfig enforces this.
+	//
+	// recvLoop reserves from this budget inline, before dispatching the
+	// frame, so a session sitting at the cap also delays ping/pong (and
+	// therefore keepalive) until the application drains enough buffered
+	// data to free room. Set KeepAliveTimeout generously relative to how
+	// long the application may leave data unread when using this option.
+	MaxReceiveBuffer int
+
+	// EnableConsumedWindowUpdates switches a stream's flow control from
+	// delta-based typeWindowUpdate frames to absolute, consumption-based
+	// typeConsumed frames (mirroring smux's UPD mode): the receiver
+	// reports cumulative bytes consumed and its window, and the sender
+	// derives remaining credit from those absolute counters instead of a
+	// running sum of deltas, avoiding the stall a bursty reader causes
+	// under the delta scheme. It's negotiated per-stream during the
+	// SYN/ACK handshake, so enabling it is safe even against a peer that
+	// doesn't support it; that peer just keeps using the default delta
+	// mode. Defaults to false.
+	EnableConsumedWindowUpdates bool
+
+	// ConsumedUpdateInterval bounds how long a receiver coalesces
+	// consumed-byte counts before emitting a typeConsumed frame, so a
+	// stream read in small increments doesn't send one frame per Read.
+	// Only meaningful when EnableConsumedWindowUpdates is set.
+	ConsumedUpdateInterval time.Duration
+
+	// LogOutput is used to control the log destination. Either
+	// Logger or LogOutput can be set, not both.
+	LogOutput io.Writer
+
+	// Logger is used to pass in the logger to be used. Either
+	// Logger or LogOutput can be set, not both.
+	Logger *log.Logger
+}
+
+// DefaultConfig is used to return a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		AcceptBacklog:          256,
+		EnableKeepAlive:        true,
+		KeepAliveInterval:      30 * time.Second,
+		KeepAliveTimeout:       90 * time.Second,
+		ConnectionWriteTimeout: 10 * time.Second,
+		MaxStreamWindowSize:    initialStreamWindow,
+		ConsumedUpdateInterval: 10 * time.Millisecond,
+		LogOutput:              os.Stderr,
+	}
+}
+
+// VerifyConfig is used to verify the sanity of configuration
+func VerifyConfig(config *Config) error {
+	if config.AcceptBacklog <= 0 {
+		return fmt.Errorf("backlog must be positive")
+	}
+	if config.KeepAliveInterval == 0 {
+		return fmt.Errorf("keep-alive interval must be positive")
+	}
+	if config.KeepAliveTimeout == 0 {
+		return fmt.Errorf("keep-alive timeout must be positive")
+	}
+	if config.MaxStreamWindowSize < initialStreamWindow {
+		return fmt.Errorf("MaxStreamWindowSize must be larger than %d", initialStreamWindow)
+	}
+	if config.EnableConsumedWindowUpdates && config.ConsumedUpdateInterval <= 0 {
+		return fmt.Errorf("ConsumedUpdateInterval must be positive when consumed window updates are enabled")
+	}
+	if config.MaxReceiveBuffer > 0 && uint32(config.MaxReceiveBuffer) < config.MaxStreamWindowSize {
+		return fmt.Errorf("MaxReceiveBuffer must be at least MaxStreamWindowSize (%d)", config.MaxStreamWindowSize)
+	}
+	return nil
+}
+
+// Server is used to initialize a new server-side connection.
+func Server(conn io.ReadWriteCloser, config *Config) (*Session, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := VerifyConfig(config); err != nil {
+		return nil, err
+	}
+	return newSession(config, conn, false), nil
+}
+
+// Client is used to initialize a new client-side connection.
+func Client(conn io.ReadWriteCloser, config *Config) (*Session, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := VerifyConfig(config); err != nil {
+		return nil, err
+	}
+	return newSession(config, conn, true), nil
+}
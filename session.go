@@ -0,0 +1,665 @@
+package yamux
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is used to wrap a reliable ordered connection and multiplex
+// it into multiple streams.
+type Session struct {
+	// remoteGoAway indicates the remote side does
+	// not want further connections. Must be first for alignment.
+	remoteGoAway int32
+
+	// localGoAway indicates that we should stop
+	// accepting further connections. Must be first for alignment.
+	localGoAway int32
+
+	// nextStreamID is the next stream we should
+	// send. This depends if we are a client/server.
+	nextStreamID uint32
+
+	// config holds our configuration
+	config *Config
+
+	// logger is used for our logs
+	logger *log.Logger
+
+	// conn is the underlying connection
+	conn io.ReadWriteCloser
+
+	// bufRead is a buffered reader
+	bufRead *bufio.Reader
+
+	// pings is used to track inflight pings
+	pings    map[uint32]chan struct{}
+	pingID   uint32
+	pingLock sync.Mutex
+
+	// streams maps a stream id to a stream. inflight is allocated but
+	// nothing adds to it; outgoing streams not yet established are
+	// counted by synCh instead.
+	streams    map[uint32]*Stream
+	inflight   map[uint32]struct{}
+	streamLock sync.Mutex
+
+	// synCh acts as a semaphore to prevent more than AcceptBacklog
+	// streams from being in the SYN state at once.
+	synCh chan struct{}
+
+	// acceptCh is used to pass ready streams to the client
+	acceptCh chan *Stream
+
+	// recvBucket is a token bucket bounding the aggregate number of
+	// bytes buffered across all streams awaiting Read, independent of
+	// any single stream's window. bucketNotifyCh wakes recvLoop once
+	// tokens are returned to the bucket. Unused (stays at zero) when
+	// Config.MaxReceiveBuffer is zero.
+	recvBucket     int32
+	bucketNotifyCh chan struct{}
+
+	// bufPool recycles the buffers streams use to hold buffered frame
+	// data, cutting GC pressure on high-throughput links.
+	bufPool sync.Pool
+
+	// writeQueue is a priority heap of frames waiting to be written.
+	// Control frames (SYN/ACK/FIN/window-updates/pings) are given
+	// higher priority than data so they aren't stuck behind a
+	// backlog of bulk writes.
+	writeQueue     writeRequestHeap
+	writeQueueLock sync.Mutex
+	writeCh        chan struct{}
+
+	// shutdown is used to safely close a session
+	shutdown     bool
+	shutdownErr  error
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+}
+
+const (
+	prioData    = 0
+	prioControl = 1
+)
+
+// writeRequest is a single pending header+body pair waiting to be
+// flushed to the connection.
+type writeRequest struct {
+	prio     int
+	hdr      header
+	body     io.Reader
+	resultCh chan error
+}
+
+// writeRequestHeap implements container/heap.Interface, draining
+// higher-priority requests first.
+type writeRequestHeap []*writeRequest
+
+func (h writeRequestHeap) Len() int            { return len(h) }
+func (h writeRequestHeap) Less(i, j int) bool  { return h[i].prio > h[j].prio }
+func (h writeRequestHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *writeRequestHeap) Push(x interface{}) { *h = append(*h, x.(*writeRequest)) }
+func (h *writeRequestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// newSession is used to construct a new session
+func newSession(config *Config, conn io.ReadWriteCloser, client bool) *Session {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(config.LogOutput, "", log.LstdFlags)
+	}
+
+	s := &Session{
+		config:         config,
+		logger:         logger,
+		conn:           conn,
+		bufRead:        bufio.NewReader(conn),
+		pings:          make(map[uint32]chan struct{}),
+		streams:        make(map[uint32]*Stream),
+		inflight:       make(map[uint32]struct{}),
+		synCh:          make(chan struct{}, config.AcceptBacklog),
+		acceptCh:       make(chan *Stream, config.AcceptBacklog),
+		writeCh:        make(chan struct{}, 1),
+		bucketNotifyCh: make(chan struct{}, 1),
+		shutdownCh:     make(chan struct{}),
+	}
+	s.recvBucket = int32(config.MaxReceiveBuffer)
+	s.bufPool.New = func() interface{} {
+		return make([]byte, config.MaxStreamWindowSize)
+	}
+	if client {
+		s.nextStreamID = 1
+	} else {
+		s.nextStreamID = 2
+	}
+
+	if config.EnableKeepAlive {
+		go s.keepalive()
+	}
+	go s.recvLoop()
+	go s.writeLoop()
+	return s
+}
+
+// Open is used to create a new stream as a net.Conn
+func (s *Session) Open() (io.ReadWriteCloser, error) {
+	conn, err := s.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// OpenStream is used to create a new stream
+func (s *Session) OpenStream() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionShutdown
+	}
+	if atomic.LoadInt32(&s.remoteGoAway) == 1 {
+		return nil, ErrRemoteGoAway
+	}
+
+	// Take a free stream slot, mirroring the backlog the remote side
+	// is willing to accept; fail at once rather than block when none
+	// is free.
+	select {
+	case s.synCh <- struct{}{}:
+	default:
+		return nil, ErrStreamsExceeded
+	}
+
+GET_ID:
+	id := atomic.LoadUint32(&s.nextStreamID)
+	if id >= math.MaxUint32-1 {
+		return nil, ErrStreamsExceeded
+	}
+	if !atomic.CompareAndSwapUint32(&s.nextStreamID, id, id+2) {
+		goto GET_ID
+	}
+
+	stream := newStream(s, id, streamInit)
+	s.streamLock.Lock()
+	s.streams[id] = stream
+	s.streamLock.Unlock()
+
+	if err := stream.sendWindowUpdate(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept is used to block until the next available stream
+// is ready to be accepted.
+func (s *Session) Accept() (io.ReadWriteCloser, error) {
+	conn, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// AcceptStream is used to block until the next available stream
+// is ready to be accepted.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.acceptCh:
+		if err := stream.sendWindowUpdate(); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	case <-s.shutdownCh:
+		return nil, s.shutdownErr
+	}
+}
+
+// Close is used to close the session and all streams.
+func (s *Session) Close() error {
+	return s.teardown(ErrSessionShutdown, false)
+}
+
+// exitErr is used to shutdown the session with an error after an
+// unrecoverable I/O failure, closing every stream the ordinary way
+// (streamClosed).
+func (s *Session) exitErr(err error) {
+	s.teardown(err, false)
+}
+
+// resetErr is used to shutdown the session after detecting a
+// non-responsive peer (a keepalive timeout). Unlike exitErr, every open
+// stream transitions to streamReset rather than streamClosed, so a
+// stream blocked in Read or Write comes back with ErrConnectionReset
+// instead of a clean io.EOF, letting callers tell a dead peer apart
+// from an ordinary close without probing themselves.
+func (s *Session) resetErr(err error) {
+	s.teardown(err, true)
+}
+
+// teardown performs the shutdown shared by Close/exitErr/resetErr: it's
+// a no-op past the first call, so whichever of them gets there first
+// decides reset vs. clean-close for every stream.
+func (s *Session) teardown(err error, reset bool) error {
+	s.shutdownLock.Lock()
+	if s.shutdown {
+		s.shutdownLock.Unlock()
+		return nil
+	}
+	s.shutdown = true
+	if s.shutdownErr == nil {
+		s.shutdownErr = err
+	}
+	close(s.shutdownCh)
+	s.shutdownLock.Unlock()
+
+	s.conn.Close()
+
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+	for _, stream := range s.streams {
+		if reset {
+			stream.forceReset()
+		} else {
+			stream.forceClose()
+		}
+	}
+	return nil
+}
+
+// IsClosed does a safe check to see if we have shutdown
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.shutdownCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// NumStreams returns the number of active streams
+func (s *Session) NumStreams() int {
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+	return len(s.streams)
+}
+
+// GoAway can be used to prevent accepting further
+// connections. It does not close the underlying conn.
+func (s *Session) GoAway() error {
+	atomic.SwapInt32(&s.localGoAway, 1)
+	hdr := header(make([]byte, headerSize))
+	hdr.encode(typeGoAway, 0, 0, 0)
+	return s.sendNoWait(hdr)
+}
+
+// Ping is used to measure the round-trip time to the peer, using the
+// same ping/pong control frames the keepalive loop relies on.
+func (s *Session) Ping() (time.Duration, error) {
+	return s.ping(s.config.ConnectionWriteTimeout)
+}
+
+// ping sends a ping frame and waits up to timeout for the matching pong.
+func (s *Session) ping(timeout time.Duration) (time.Duration, error) {
+	id := atomic.AddUint32(&s.pingID, 1)
+
+	ch := make(chan struct{})
+	s.pingLock.Lock()
+	s.pings[id] = ch
+	s.pingLock.Unlock()
+	defer func() {
+		s.pingLock.Lock()
+		delete(s.pings, id)
+		s.pingLock.Unlock()
+	}()
+
+	hdr := header(make([]byte, headerSize))
+	hdr.encode(typePing, 0, 0, id)
+	start := time.Now()
+	if err := s.sendNoWait(hdr); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+		return 0, ErrTimeout
+	case <-s.shutdownCh:
+		return 0, ErrSessionShutdown
+	}
+	return time.Since(start), nil
+}
+
+// keepalive periodically pings the remote side to keep the connection
+// alive, tearing the session down if a ping goes unanswered for longer
+// than KeepAliveTimeout.
+func (s *Session) keepalive() {
+	for {
+		select {
+		case <-time.After(s.config.KeepAliveInterval):
+			if _, err := s.ping(s.config.KeepAliveTimeout); err != nil {
+				s.logger.Printf("[ERR] yamux: keepalive failed: %v", err)
+				s.resetErr(ErrKeepAliveTimeout)
+				return
+			}
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// getRecvBuf returns a buffer of exactly n bytes, reusing one from the
+// pool when it's large enough rather than allocating.
+func (s *Session) getRecvBuf(n uint32) []byte {
+	buf := s.bufPool.Get().([]byte)
+	if uint32(cap(buf)) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// putRecvBuf returns a buffer obtained from getRecvBuf to the pool
+// once a stream is done with it.
+func (s *Session) putRecvBuf(buf []byte) {
+	s.bufPool.Put(buf[:0]) //nolint:staticcheck // capacity is what we reuse, not the slice itself
+}
+
+// reserveRecv reserves n bytes from the session-wide receive bucket,
+// blocking the caller (the session's recvLoop) until enough tokens are
+// available. It is a no-op when Config.MaxReceiveBuffer is zero.
+//
+// Because this runs inline in recvLoop before a frame is dispatched, it
+// also blocks dispatch of every other frame type on the wire behind it
+// — including ping/pong — for as long as the bucket stays empty. See
+// the Config.MaxReceiveBuffer doc comment for the keepalive
+// implication.
+func (s *Session) reserveRecv(n uint32) error {
+	if s.config.MaxReceiveBuffer <= 0 {
+		return nil
+	}
+	for {
+		if atomic.AddInt32(&s.recvBucket, -int32(n)) >= 0 {
+			return nil
+		}
+		// Didn't fit; give back what we just took and wait for room.
+		atomic.AddInt32(&s.recvBucket, int32(n))
+		select {
+		case <-s.bucketNotifyCh:
+		case <-s.shutdownCh:
+			return ErrSessionShutdown
+		}
+	}
+}
+
+// returnTokens gives n bytes back to the session-wide receive bucket,
+// e.g. once a stream's Read has drained them out of its buffer.
+func (s *Session) returnTokens(n uint32) {
+	if s.config.MaxReceiveBuffer <= 0 || n == 0 {
+		return
+	}
+	atomic.AddInt32(&s.recvBucket, int32(n))
+	asyncNotify(s.bucketNotifyCh)
+}
+
+// establishStream is used to mark a stream as established, releasing
+// the slot held in synCh.
+func (s *Session) establishStream(id uint32) {
+	select {
+	case <-s.synCh:
+	default:
+		s.logger.Printf("[ERR] yamux: established stream without inflight SYN (id: %d)", id)
+	}
+}
+
+// closeStream is used to close a stream once both sides have
+// finished with it.
+func (s *Session) closeStream(id uint32) {
+	s.streamLock.Lock()
+	stream, ok := s.streams[id]
+	delete(s.streams, id)
+	s.streamLock.Unlock()
+
+	if ok {
+		stream.releaseRecvBuf()
+	}
+}
+
+// sendNoWait is used to send a control frame without a caller blocked
+// on a dedicated error channel.
+func (s *Session) sendNoWait(hdr header) error {
+	errCh := make(chan error, 1)
+	return s.waitForSendErr(hdr, nil, errCh)
+}
+
+// waitForSendErr enqueues hdr/body onto the write queue and blocks
+// until it has been flushed to the connection, the session shuts
+// down, or ConnectionWriteTimeout elapses.
+func (s *Session) waitForSendErr(hdr header, body io.Reader, errCh chan error) error {
+	prio := prioData
+	if hdr.MsgType() != typeData {
+		prio = prioControl
+	}
+
+	timer := time.NewTimer(s.config.ConnectionWriteTimeout)
+	defer timer.Stop()
+
+	req := &writeRequest{prio: prio, hdr: hdr, body: body, resultCh: errCh}
+
+	s.writeQueueLock.Lock()
+	heap.Push(&s.writeQueue, req)
+	s.writeQueueLock.Unlock()
+	asyncNotify(s.writeCh)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-s.shutdownCh:
+		return ErrSessionShutdown
+	case <-timer.C:
+		return ErrConnectionWriteTimeout
+	}
+}
+
+// writeLoop drains the write queue, coalescing everything queued into a
+// single net.Buffers per batch rather than one write per frame. Frames
+// queued while a batch is being flushed form the next batch of the same
+// wakeup.
+func (s *Session) writeLoop() {
+	for {
+		select {
+		case <-s.writeCh:
+		case <-s.shutdownCh:
+			return
+		}
+
+		for {
+			s.writeQueueLock.Lock()
+			n := s.writeQueue.Len()
+			if n == 0 {
+				s.writeQueueLock.Unlock()
+				break
+			}
+			reqs := make([]*writeRequest, 0, n)
+			for s.writeQueue.Len() > 0 {
+				reqs = append(reqs, heap.Pop(&s.writeQueue).(*writeRequest))
+			}
+			s.writeQueueLock.Unlock()
+
+			if err := s.flushWrites(reqs); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// flushWrites gathers the header and body of every queued request into
+// a single net.Buffers and writes it out in one call, which is a single
+// writev when the connection supports it.
+func (s *Session) flushWrites(reqs []*writeRequest) error {
+	bufs := make(net.Buffers, 0, len(reqs)*2)
+	live := make([]*writeRequest, 0, len(reqs))
+	for _, req := range reqs {
+		if req.body != nil {
+			body, err := io.ReadAll(req.body)
+			if err != nil {
+				asyncSendErr(req.resultCh, err)
+				continue
+			}
+			bufs = append(bufs, []byte(req.hdr), body)
+		} else {
+			bufs = append(bufs, []byte(req.hdr))
+		}
+		live = append(live, req)
+	}
+
+	if len(bufs) == 0 {
+		return nil
+	}
+
+	if conn, ok := s.conn.(net.Conn); ok && s.config.ConnectionWriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.config.ConnectionWriteTimeout))
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	_, err := bufs.WriteTo(s.conn)
+	for _, req := range live {
+		asyncSendErr(req.resultCh, err)
+	}
+	if err != nil {
+		s.exitErr(err)
+		return err
+	}
+	return nil
+}
+
+// recvLoop continuously reads frame headers off the connection and
+// dispatches them until the connection fails or the session closes.
+func (s *Session) recvLoop() {
+	hdr := header(make([]byte, headerSize))
+	for {
+		if _, err := io.ReadFull(s.bufRead, hdr); err != nil {
+			if !s.IsClosed() {
+				s.exitErr(err)
+			}
+			return
+		}
+		if hdr.Version() != protoVersion {
+			s.logger.Printf("[ERR] yamux: invalid protocol version: %d", hdr.Version())
+			s.exitErr(ErrInvalidVersion)
+			return
+		}
+		if err := s.handleStreamMessage(hdr); err != nil {
+			s.exitErr(err)
+			return
+		}
+	}
+}
+
+// handleStreamMessage dispatches a single received frame.
+func (s *Session) handleStreamMessage(hdr header) error {
+	switch hdr.MsgType() {
+	case typePing:
+		return s.handlePing(hdr)
+	case typeGoAway:
+		atomic.SwapInt32(&s.remoteGoAway, 1)
+		return nil
+	}
+
+	id := hdr.StreamID()
+	flags := hdr.Flags()
+
+	if flags&flagSYN == flagSYN {
+		if err := s.incomingStream(id, flags); err != nil {
+			return err
+		}
+	}
+
+	s.streamLock.Lock()
+	stream := s.streams[id]
+	s.streamLock.Unlock()
+
+	if stream == nil {
+		if (hdr.MsgType() == typeData || hdr.MsgType() == typeConsumed) && hdr.Length() > 0 {
+			s.logger.Printf("[WARN] yamux: Discarding data for stream: %d", id)
+			if _, err := io.CopyN(io.Discard, s.bufRead, int64(hdr.Length())); err != nil {
+				return err
+			}
+		} else {
+			s.logger.Printf("[WARN] yamux: Discarding frame for stream: %d", id)
+		}
+		return nil
+	}
+
+	switch hdr.MsgType() {
+	case typeWindowUpdate:
+		return stream.incrSendWindow(hdr, flags)
+	case typeData:
+		return stream.readData(hdr, flags, s.bufRead)
+	case typeConsumed:
+		return stream.handleConsumed(hdr, s.bufRead)
+	default:
+		return ErrInvalidMsgType
+	}
+}
+
+// incomingStream registers a newly SYN'd stream and hands it to the
+// accept queue. flags is the SYN frame's flags, so a proposal to use
+// consumed-mode flow control (see flagConsumedMode) can be recorded
+// before the stream is published.
+func (s *Session) incomingStream(id uint32, flags uint16) error {
+	if atomic.LoadInt32(&s.localGoAway) == 1 {
+		hdr := header(make([]byte, headerSize))
+		hdr.encode(typeWindowUpdate, flagRST, id, 0)
+		return s.sendNoWait(hdr)
+	}
+
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+
+	if _, ok := s.streams[id]; ok {
+		s.logger.Printf("[ERR] yamux: duplicate stream declared")
+		return ErrDuplicateStream
+	}
+
+	stream := newStream(s, id, streamSYNReceived)
+	stream.peerProposedConsumed = flags&flagConsumedMode == flagConsumedMode
+
+	select {
+	case s.acceptCh <- stream:
+		s.streams[id] = stream
+	default:
+		s.logger.Printf("[WARN] yamux: backlog exceeded, forcing connection reset")
+	}
+	return nil
+}
+
+// handlePing responds to (or resolves) a ping frame.
+func (s *Session) handlePing(hdr header) error {
+	flags := hdr.Flags()
+	pingID := hdr.Length()
+
+	if flags&flagACK == flagACK {
+		s.pingLock.Lock()
+		ch, ok := s.pings[pingID]
+		s.pingLock.Unlock()
+		if ok {
+			close(ch)
+		}
+		return nil
+	}
+
+	reply := header(make([]byte, headerSize))
+	reply.encode(typePing, flagACK, 0, pingID)
+	return s.sendNoWait(reply)
+}
@@ -2,7 +2,6 @@ package yamux
 
 import (
 	"bytes"
-	"context"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -34,8 +33,15 @@ type Stream struct {
 	state     streamState
 	stateLock sync.Mutex
 
-	recvBuf  *bytes.Buffer
-	recvLock sync.Mutex
+	// recvBuf holds the unread remainder of each buffered data frame, in
+	// arrival order; recvHeads holds the corresponding original
+	// allocations (as handed out by the session's buffer pool) so they
+	// can be recycled once fully drained. Read/WriteTo advance recvBuf's
+	// slice headers in place rather than copying into a single backing
+	// array.
+	recvBuf   [][]byte
+	recvHeads [][]byte
+	recvLock  sync.Mutex
 
 	controlHdr     header
 	controlErr     chan error
@@ -48,13 +54,65 @@ type Stream struct {
 	recvNotifyCh chan struct{}
 	sendNotifyCh chan struct{}
 
+	// Consumed-mode flow control: opt-in via Config.EnableConsumedWindowUpdates
+	// and negotiated per-stream during the SYN/ACK handshake (see
+	// negotiationFlags and processFlags). Once active, Read/WriteTo
+	// report cumulative bytes consumed via typeConsumed frames instead
+	// of the legacy delta-based typeWindowUpdate, and write() derives
+	// credit from the peer's last reported (consumed, window) pair
+	// rather than from sendWindow.
+	peerProposedConsumed bool  // set once, before the stream is published; SYN only
+	consumedActive       int32 // atomic: 1 once both sides have agreed
+
+	bytesConsumed uint32 // cumulative bytes drained by Read/WriteTo
+	consumedSent  uint32 // bytesConsumed as of the last emitted typeConsumed frame
+	consumedTimer *time.Timer
+	consumedLock  sync.Mutex
+
+	bytesWritten uint32 // cumulative bytes handed to write()
+	peerConsumed uint32 // consumed_bytes from the peer's last typeConsumed frame
+	peerWindow   uint32 // current_window from the peer's last typeConsumed frame
+	peerLock     sync.Mutex
+
+	readDeadline atomic.Value // *streamDeadline
+
 	readDeadlineLock sync.Mutex
 	readTimer        *time.Timer
-	readTimedOut     uint32
+
+	writeDeadline atomic.Value // *streamDeadline
 
 	writeDeadlineLock sync.Mutex
 	writeTimer        *time.Timer
-	writeTimedOut     uint32
+
+	// lastActivity is the UnixNano time of the most recent read or write
+	// observed on this stream, in either direction; see touch/LastActivity.
+	lastActivity int64
+}
+
+// streamDeadline wraps a deadline time together with a notification channel
+// that is closed exactly once, either when the deadline elapses or when it
+// is replaced by a new call to SetReadDeadline/SetWriteDeadline. Replacing a
+// deadline this way lets a blocked Read/write wake immediately and pick up
+// the new deadline rather than waiting on a stale timer.
+type streamDeadline struct {
+	t    time.Time
+	ch   chan struct{}
+	once sync.Once
+}
+
+func newStreamDeadline() *streamDeadline {
+	return &streamDeadline{ch: make(chan struct{})}
+}
+
+// expire closes the notification channel, if it hasn't been already.
+func (d *streamDeadline) expire() {
+	d.once.Do(func() { close(d.ch) })
+}
+
+// timedOut reports whether this deadline has a non-zero time that has
+// already elapsed.
+func (d *streamDeadline) timedOut() bool {
+	return !d.t.IsZero() && !d.t.After(time.Now())
 }
 
 // newStream is used to construct a new stream within
@@ -70,9 +128,13 @@ func newStream(session *Session, id uint32, state streamState) *Stream {
 		sendErr:      make(chan error, 1),
 		recvWindow:   initialStreamWindow,
 		sendWindow:   initialStreamWindow,
+		peerWindow:   initialStreamWindow,
 		recvNotifyCh: make(chan struct{}, 1),
 		sendNotifyCh: make(chan struct{}, 1),
 	}
+	s.readDeadline.Store(newStreamDeadline())
+	s.writeDeadline.Store(newStreamDeadline())
+	s.touch()
 	return s
 }
 
@@ -86,19 +148,27 @@ func (s *Stream) StreamID() uint32 {
 	return s.id
 }
 
+// touch records the current time as this stream's most recent activity.
+func (s *Stream) touch() {
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+// LastActivity returns the time of the most recent read or write
+// activity observed on this stream, in either direction. Callers can
+// use it to judge per-stream idleness independent of the session-wide
+// keepalive.
+func (s *Stream) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastActivity))
+}
+
 // Read is used to read from the stream
 func (s *Stream) Read(b []byte) (n int, err error) {
 	defer asyncNotify(s.recvNotifyCh)
 
-	if s.isReadTimedOut() {
-		return 0, timeoutError{}
+	if s.readDeadline.Load().(*streamDeadline).timedOut() {
+		return 0, ErrTimeout
 	}
 
-	timeout := make(chan struct{})
-
-	cancel := s.timeoutObserver(timeout, s.isReadTimedOut)
-	defer cancel()
-
 	for {
 		s.stateLock.Lock()
 		switch s.state {
@@ -108,7 +178,7 @@ func (s *Stream) Read(b []byte) (n int, err error) {
 			fallthrough
 		case streamClosed:
 			s.recvLock.Lock()
-			if s.recvBuf == nil || s.recvBuf.Len() == 0 {
+			if len(s.recvBuf) == 0 {
 				s.recvLock.Unlock()
 				s.stateLock.Unlock()
 				return 0, io.EOF
@@ -122,23 +192,126 @@ func (s *Stream) Read(b []byte) (n int, err error) {
 
 		// If there is no data available, block
 		s.recvLock.Lock()
-		if s.recvBuf == nil || s.recvBuf.Len() == 0 {
+		if len(s.recvBuf) == 0 {
 			s.recvLock.Unlock()
 		} else {
-			// Read any bytes
-			n, _ = s.recvBuf.Read(b)
+			// Drain as much of the chain as fits in b
+			n = s.drainRecvLocked(b)
 			s.recvLock.Unlock()
 
-			// Send a window update potentially
-			err = s.sendWindowUpdate()
+			// Return the bytes we just drained to the session-wide
+			// receive bucket
+			s.session.returnTokens(uint32(n))
+
+			// Report the bytes we just handed out, either as a
+			// consumed-mode update or a legacy window delta
+			if s.consumedModeActive() {
+				err = s.noteConsumed(uint32(n))
+			} else {
+				err = s.sendWindowUpdate()
+			}
 			return n, err
 		}
 
+		dl := s.readDeadline.Load().(*streamDeadline)
 		select {
 		case <-s.recvNotifyCh:
 			continue
-		case <-timeout:
-			return 0, ErrTimeout
+		case <-dl.ch:
+			if dl.timedOut() {
+				return 0, ErrTimeout
+			}
+			// The deadline was replaced rather than elapsed; loop
+			// around and wait on the new one.
+			continue
+		}
+	}
+}
+
+// drainRecvLocked copies buffered frames into b, advancing past (and
+// recycling) any frame fully consumed. recvLock must be held.
+func (s *Stream) drainRecvLocked(b []byte) int {
+	total := 0
+	for len(b) > 0 && len(s.recvBuf) > 0 {
+		chunk := s.recvBuf[0]
+		n := copy(b, chunk)
+		total += n
+		b = b[n:]
+
+		if n == len(chunk) {
+			s.session.putRecvBuf(s.recvHeads[0])
+			s.recvBuf = s.recvBuf[1:]
+			s.recvHeads = s.recvHeads[1:]
+		} else {
+			s.recvBuf[0] = chunk[n:]
+		}
+	}
+	return total
+}
+
+// WriteTo implements io.WriterTo. It hands whole buffered frames
+// directly to w, letting io.Copy(dst, stream) avoid the intermediate
+// copy that Read requires.
+func (s *Stream) WriteTo(w io.Writer) (n int64, err error) {
+	defer asyncNotify(s.recvNotifyCh)
+
+	for {
+		s.stateLock.Lock()
+		switch s.state {
+		case streamLocalClose:
+			fallthrough
+		case streamRemoteClose:
+			fallthrough
+		case streamClosed:
+			s.recvLock.Lock()
+			empty := len(s.recvBuf) == 0
+			s.recvLock.Unlock()
+			if empty {
+				s.stateLock.Unlock()
+				return n, nil
+			}
+		case streamReset:
+			s.stateLock.Unlock()
+			return n, ErrConnectionReset
+		}
+		s.stateLock.Unlock()
+
+		s.recvLock.Lock()
+		if len(s.recvBuf) == 0 {
+			s.recvLock.Unlock()
+		} else {
+			chunk := s.recvBuf[0]
+			head := s.recvHeads[0]
+			s.recvBuf = s.recvBuf[1:]
+			s.recvHeads = s.recvHeads[1:]
+			s.recvLock.Unlock()
+
+			wn, werr := w.Write(chunk)
+			n += int64(wn)
+			s.session.returnTokens(uint32(len(chunk)))
+			s.session.putRecvBuf(head)
+			if werr != nil {
+				return n, werr
+			}
+			if s.consumedModeActive() {
+				if err := s.noteConsumed(uint32(len(chunk))); err != nil {
+					return n, err
+				}
+			} else if err := s.sendWindowUpdate(); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		dl := s.readDeadline.Load().(*streamDeadline)
+		select {
+		case <-s.recvNotifyCh:
+			continue
+		case <-dl.ch:
+			if dl.timedOut() {
+				return n, ErrTimeout
+			}
+			continue
 		}
 	}
 }
@@ -165,15 +338,10 @@ func (s *Stream) write(b []byte) (n int, err error) {
 	var max uint32
 	var body io.Reader
 
-	if s.isWriteTimedOut() {
-		return 0, timeoutError{}
+	if s.writeDeadline.Load().(*streamDeadline).timedOut() {
+		return 0, ErrTimeout
 	}
 
-	timeout := make(chan struct{})
-
-	cancel := s.timeoutObserver(timeout, s.isWriteTimedOut)
-	defer cancel()
-
 	for {
 		s.stateLock.Lock()
 		switch s.state {
@@ -189,7 +357,7 @@ func (s *Stream) write(b []byte) (n int, err error) {
 		s.stateLock.Unlock()
 
 		// If there is no data available, block
-		window := atomic.LoadUint32(&s.sendWindow)
+		window := s.sendCredit()
 		if window != 0 {
 			// Determine the flags if any
 			flags = s.sendFlags()
@@ -205,21 +373,72 @@ func (s *Stream) write(b []byte) (n int, err error) {
 			}
 
 			// Reduce our send window
-			atomic.AddUint32(&s.sendWindow, ^uint32(max-1))
+			s.consumeSendCredit(max)
+			s.touch()
 
 			// Unlock
 			return int(max), err
 		}
 
+		dl := s.writeDeadline.Load().(*streamDeadline)
 		select {
 		case <-s.sendNotifyCh:
 			continue
-		case <-timeout:
-			return 0, ErrTimeout
+		case <-dl.ch:
+			if dl.timedOut() {
+				return 0, ErrTimeout
+			}
+			// The deadline was replaced rather than elapsed; loop
+			// around and wait on the new one.
+			continue
 		}
 	}
 }
 
+// consumedModeActive reports whether this stream has negotiated
+// consumption-based window updates with its peer.
+func (s *Stream) consumedModeActive() bool {
+	return atomic.LoadInt32(&s.consumedActive) == 1
+}
+
+// sendCredit returns the number of bytes we may currently write: the
+// peer's last reported window minus what we've written since its last
+// reported consumed count, when consumed-mode is active, or the legacy
+// sendWindow otherwise.
+func (s *Stream) sendCredit() uint32 {
+	if !s.consumedModeActive() {
+		return atomic.LoadUint32(&s.sendWindow)
+	}
+	s.peerLock.Lock()
+	defer s.peerLock.Unlock()
+	inFlight := s.bytesWritten - s.peerConsumed
+	if inFlight > s.peerWindow {
+		// The peer's typeConsumed report raced activation and covers
+		// bytes consumeSendCredit already folded into bytesWritten
+		// before this stream had a peerWindow to compare against (or
+		// consumedActive flipped between the two); treat it as no
+		// credit rather than underflowing.
+		return 0
+	}
+	return s.peerWindow - inFlight
+}
+
+// consumeSendCredit records that n bytes were just written, so a
+// subsequent sendCredit reflects them. bytesWritten is tracked
+// unconditionally, even for writes issued before consumed-mode
+// activates, so sendCredit's later subtraction against the peer's
+// reported consumed count (which itself doesn't distinguish
+// pre-/post-activation bytes) can't underflow.
+func (s *Stream) consumeSendCredit(n uint32) {
+	s.peerLock.Lock()
+	s.bytesWritten += n
+	s.peerLock.Unlock()
+
+	if !s.consumedModeActive() {
+		atomic.AddUint32(&s.sendWindow, ^uint32(n-1))
+	}
+}
+
 // sendFlags determines any flags that are appropriate
 // based on the current stream state
 func (s *Stream) sendFlags() uint16 {
@@ -247,13 +466,14 @@ func (s *Stream) sendWindowUpdate() error {
 	max := s.session.config.MaxStreamWindowSize
 	var bufLen uint32
 	s.recvLock.Lock()
-	if s.recvBuf != nil {
-		bufLen = uint32(s.recvBuf.Len())
+	for _, chunk := range s.recvBuf {
+		bufLen += uint32(len(chunk))
 	}
 	delta := (max - bufLen) - s.recvWindow
 
-	// Determine the flags if any
-	flags := s.sendFlags()
+	// Determine the flags if any, including a consumed-mode proposal or
+	// confirmation piggybacked on the SYN/ACK
+	flags := s.negotiationFlags(s.sendFlags())
 
 	// Check if we can omit the update
 	if delta < (max/2) && flags == 0 {
@@ -273,6 +493,76 @@ func (s *Stream) sendWindowUpdate() error {
 	return nil
 }
 
+// negotiationFlags augments base (the result of sendFlags) with
+// flagConsumedMode when consumed-mode flow control should be proposed
+// (on our SYN) or confirmed (on our ACK, now that the peer's SYN
+// proposed it and our own Config agrees). Confirming activates the mode
+// on this side immediately; the peer activates it once our ACK arrives,
+// via processFlags.
+func (s *Stream) negotiationFlags(base uint16) uint16 {
+	if !s.session.config.EnableConsumedWindowUpdates {
+		return base
+	}
+	switch {
+	case base&flagSYN == flagSYN:
+		return base | flagConsumedMode
+	case base&flagACK == flagACK && s.peerProposedConsumed:
+		atomic.StoreInt32(&s.consumedActive, 1)
+		return base | flagConsumedMode
+	}
+	return base
+}
+
+// noteConsumed records that n additional bytes have been handed to the
+// caller by Read or WriteTo under consumed-mode flow control, emitting
+// a typeConsumed frame immediately once enough has accumulated to cross
+// half the window, or coalescing a smaller trickle of reads into one
+// frame via ConsumedUpdateInterval.
+func (s *Stream) noteConsumed(n uint32) error {
+	if n == 0 {
+		return nil
+	}
+
+	s.consumedLock.Lock()
+	s.bytesConsumed += n
+	pending := s.bytesConsumed - s.consumedSent
+	max := s.session.config.MaxStreamWindowSize
+	if pending >= max/2 {
+		s.consumedLock.Unlock()
+		return s.sendConsumed()
+	}
+	if s.consumedTimer == nil {
+		s.consumedTimer = time.AfterFunc(s.session.config.ConsumedUpdateInterval, func() {
+			if err := s.sendConsumed(); err != nil {
+				s.session.logger.Printf("[ERR] yamux: failed to send consumed update: %v", err)
+			}
+		})
+	}
+	s.consumedLock.Unlock()
+	return nil
+}
+
+// sendConsumed emits a typeConsumed frame reporting the stream's
+// cumulative consumed-byte count and window, canceling any pending
+// coalescing timer.
+func (s *Stream) sendConsumed() error {
+	s.consumedLock.Lock()
+	if s.consumedTimer != nil {
+		s.consumedTimer.Stop()
+		s.consumedTimer = nil
+	}
+	consumed := s.bytesConsumed
+	s.consumedSent = consumed
+	s.consumedLock.Unlock()
+
+	body := encodeConsumed(consumed, s.session.config.MaxStreamWindowSize)
+
+	s.controlHdrLock.Lock()
+	defer s.controlHdrLock.Unlock()
+	s.controlHdr.encode(typeConsumed, 0, s.id, sizeOfConsumed)
+	return s.session.waitForSendErr(s.controlHdr, bytes.NewReader(body), s.controlErr)
+}
+
 // sendClose is used to send a FIN
 func (s *Stream) sendClose() error {
 	s.controlHdrLock.Lock()
@@ -330,6 +620,19 @@ func (s *Stream) forceClose() {
 	s.state = streamClosed
 	s.stateLock.Unlock()
 	s.notifyWaiting()
+	s.releaseRecvBuf()
+}
+
+// forceReset is used when the session detects a non-responsive peer
+// (a keepalive timeout): unlike forceClose, it puts the stream into
+// streamReset so a blocked Read or Write comes back with
+// ErrConnectionReset instead of a clean io.EOF.
+func (s *Stream) forceReset() {
+	s.stateLock.Lock()
+	s.state = streamReset
+	s.stateLock.Unlock()
+	s.notifyWaiting()
+	s.releaseRecvBuf()
 }
 
 // processFlags is used to update the state of the stream
@@ -345,6 +648,12 @@ func (s *Stream) processFlags(flags uint16) error {
 
 	s.stateLock.Lock()
 	defer s.stateLock.Unlock()
+	if flags&(flagConsumedMode|flagACK) == flagConsumedMode|flagACK {
+		// Our SYN proposed consumed-mode and the peer's ACK confirmed
+		// it; activate it on this side too (the responder already did,
+		// in negotiationFlags, when it sent that confirmation).
+		atomic.StoreInt32(&s.consumedActive, 1)
+	}
 	if flags&flagACK == flagACK {
 		if s.state == streamSYNSent {
 			s.state = streamEstablished
@@ -388,6 +697,7 @@ func (s *Stream) incrSendWindow(hdr header, flags uint16) error {
 	if err := s.processFlags(flags); err != nil {
 		return err
 	}
+	s.touch()
 
 	// Increase window, unblock a sender
 	atomic.AddUint32(&s.sendWindow, hdr.Length())
@@ -395,11 +705,41 @@ func (s *Stream) incrSendWindow(hdr header, flags uint16) error {
 	return nil
 }
 
+// handleConsumed processes an incoming typeConsumed frame, recording
+// the peer's reported absolute consumed/window counters and waking any
+// writer blocked on send credit.
+func (s *Stream) handleConsumed(hdr header, conn io.Reader) error {
+	length := hdr.Length()
+	if length != sizeOfConsumed {
+		// A well-formed typeConsumed frame always carries exactly the
+		// two-counter body; anything else is rejected before
+		// allocating, since length is attacker-controlled and otherwise
+		// unbounded (unlike typeData, this path isn't gated by
+		// recvWindow).
+		return ErrInvalidConsumedFrame
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+	s.touch()
+
+	consumed, window := decodeConsumed(body)
+	s.peerLock.Lock()
+	s.peerConsumed = consumed
+	s.peerWindow = window
+	s.peerLock.Unlock()
+
+	asyncNotify(s.sendNotifyCh)
+	return nil
+}
+
 // readData is used to handle a data frame
 func (s *Stream) readData(hdr header, flags uint16, conn io.Reader) error {
 	if err := s.processFlags(flags); err != nil {
 		return err
 	}
+	s.touch()
 
 	// Check that our recv window is not exceeded
 	length := hdr.Length()
@@ -407,30 +747,58 @@ func (s *Stream) readData(hdr header, flags uint16, conn io.Reader) error {
 		return nil
 	}
 
-	// Wrap in a limited reader
-	conn = &io.LimitedReader{R: conn, N: int64(length)}
-
-	// Copy into buffer
+	// Reject an oversize frame before reserving any bucket tokens or
+	// allocating a buffer for it: length comes straight off the wire, so
+	// the bound must be enforced ahead of the allocation it would
+	// otherwise justify, not after reading the frame into one.
+	//
+	// Consumed-mode flow control never replenishes recvWindow (the only
+	// place that happens, sendWindowUpdate, is no longer called once the
+	// mode is active), so gating on it here would eventually underflow
+	// and tear the session down mid-transfer. The sender is already
+	// bounded by MaxStreamWindowSize, the window we report in our
+	// typeConsumed frames, so check against that instead; reserveRecv's
+	// own bound (Config.MaxReceiveBuffer, which may be unset) isn't
+	// enough on its own, since it treats any length above 2 GiB as a
+	// negative reservation and wrongly lets it through.
 	s.recvLock.Lock()
-
-	if length > s.recvWindow {
+	oversize := length > s.recvWindow
+	if s.consumedModeActive() {
+		oversize = length > s.session.config.MaxStreamWindowSize
+	}
+	if oversize {
 		s.session.logger.Printf("[ERR] yamux: receive window exceeded (stream: %d, remain: %d, recv: %d)", s.id, s.recvWindow, length)
+		s.recvLock.Unlock()
 		return ErrRecvWindowExceeded
 	}
+	s.recvLock.Unlock()
 
-	if s.recvBuf == nil {
-		// Allocate the receive buffer just-in-time to fit the full data frame.
-		// This way we can read in the whole packet without further allocations.
-		s.recvBuf = bytes.NewBuffer(make([]byte, 0, length))
+	// Reserve room in the session-wide receive bucket. This blocks the
+	// session's recvLoop (and therefore all streams) if the peer has
+	// buffered more than Config.MaxReceiveBuffer bytes awaiting Read.
+	if err := s.session.reserveRecv(length); err != nil {
+		return err
 	}
-	if _, err := io.Copy(s.recvBuf, conn); err != nil {
+
+	// Pull the frame off the wire into a pooled buffer before taking
+	// recvLock, so the chain only ever holds whole, already-read frames.
+	buf := s.session.getRecvBuf(length)
+	if _, err := io.ReadFull(conn, buf); err != nil {
 		s.session.logger.Printf("[ERR] yamux: Failed to read stream data: %v", err)
-		s.recvLock.Unlock()
+		s.session.returnTokens(length)
 		return err
 	}
 
-	// Decrement the receive window
-	s.recvWindow -= length
+	s.recvLock.Lock()
+	if !s.consumedModeActive() {
+		s.recvWindow -= length
+	}
+
+	// Append the whole frame to the chain rather than copying it into a
+	// shared buffer; Read/WriteTo will hand it out (and eventually
+	// recycle it) without any further copying.
+	s.recvBuf = append(s.recvBuf, buf)
+	s.recvHeads = append(s.recvHeads, buf)
 	s.recvLock.Unlock()
 
 	// Unblock any readers
@@ -454,25 +822,27 @@ func (s *Stream) SetReadDeadline(t time.Time) error {
 	s.readDeadlineLock.Lock()
 	defer s.readDeadlineLock.Unlock()
 
-	s.setReadTimedOut(false)
-
-	d := time.Until(t)
-	if t.IsZero() || d < 0 {
-		if s.readTimer != nil {
-			s.readTimer.Stop()
-		}
-
+	if s.readTimer != nil {
+		s.readTimer.Stop()
 		s.readTimer = nil
-	} else {
-		// Interrupt I/O operation once timer has expired
-		s.readTimer = time.AfterFunc(d, func() {
-			s.setReadTimedOut(true)
-		})
 	}
 
-	if !t.IsZero() && d < 0 {
-		// Interrupt current I/O operation
-		s.setReadTimedOut(true)
+	old := s.readDeadline.Load().(*streamDeadline)
+	next := newStreamDeadline()
+	next.t = t
+	s.readDeadline.Store(next)
+
+	// Wake anything blocked on the previous deadline so it picks up
+	// this one instead of waiting for the old timer to fire.
+	old.expire()
+
+	switch {
+	case t.IsZero():
+		// Deadline disabled.
+	case next.timedOut():
+		next.expire()
+	default:
+		s.readTimer = time.AfterFunc(time.Until(t), next.expire)
 	}
 
 	return nil
@@ -483,86 +853,60 @@ func (s *Stream) SetWriteDeadline(t time.Time) error {
 	s.writeDeadlineLock.Lock()
 	defer s.writeDeadlineLock.Unlock()
 
-	s.setWriteTimedOut(false)
-
-	d := time.Until(t)
-	if t.IsZero() || d < 0 {
-		if s.writeTimer != nil {
-			s.writeTimer.Stop()
-		}
-
+	if s.writeTimer != nil {
+		s.writeTimer.Stop()
 		s.writeTimer = nil
-	} else {
-		// Interrupt I/O operation once timer has expired
-		s.writeTimer = time.AfterFunc(d, func() {
-			s.setWriteTimedOut(true)
-		})
 	}
 
-	if !t.IsZero() && d < 0 {
-		// Interrupt current I/O operation
-		s.setWriteTimedOut(true)
+	old := s.writeDeadline.Load().(*streamDeadline)
+	next := newStreamDeadline()
+	next.t = t
+	s.writeDeadline.Store(next)
+
+	// Wake anything blocked on the previous deadline so it picks up
+	// this one instead of waiting for the old timer to fire.
+	old.expire()
+
+	switch {
+	case t.IsZero():
+		// Deadline disabled.
+	case next.timedOut():
+		next.expire()
+	default:
+		s.writeTimer = time.AfterFunc(time.Until(t), next.expire)
 	}
 
 	return nil
 }
 
+// releaseRecvBuf recycles any frames still sitting unread in recvBuf
+// and returns their outstanding reservation to the session-wide receive
+// bucket, so a stream torn down (closeStream, forceClose, forceReset)
+// before the caller has drained it via Read/WriteTo doesn't leave the
+// bucket permanently short and stall recvLoop for the rest of the
+// session.
+func (s *Stream) releaseRecvBuf() {
+	s.recvLock.Lock()
+	var outstanding uint32
+	for i, chunk := range s.recvBuf {
+		outstanding += uint32(len(chunk))
+		s.session.putRecvBuf(s.recvHeads[i])
+	}
+	s.recvBuf = nil
+	s.recvHeads = nil
+	s.recvLock.Unlock()
+
+	s.session.returnTokens(outstanding)
+}
+
 // Shrink is used to compact the amount of buffers utilized
 // This is useful when using Yamux in a connection pool to reduce
 // the idle memory utilization.
 func (s *Stream) Shrink() {
 	s.recvLock.Lock()
-	if s.recvBuf != nil && s.recvBuf.Len() == 0 {
+	if len(s.recvBuf) == 0 {
 		s.recvBuf = nil
+		s.recvHeads = nil
 	}
 	s.recvLock.Unlock()
 }
-
-func (s *Stream) isReadTimedOut() bool {
-	return atomic.LoadUint32(&s.readTimedOut) != 0
-}
-
-func (s *Stream) setReadTimedOut(timedOut bool) {
-	if timedOut {
-		atomic.StoreUint32(&s.readTimedOut, 1)
-		return
-	}
-
-	atomic.StoreUint32(&s.readTimedOut, 0)
-}
-
-func (s *Stream) isWriteTimedOut() bool {
-	return atomic.LoadUint32(&s.writeTimedOut) != 0
-}
-
-func (s *Stream) setWriteTimedOut(timedOut bool) {
-	if timedOut {
-		atomic.StoreUint32(&s.writeTimedOut, 1)
-		return
-	}
-
-	atomic.StoreUint32(&s.writeTimedOut, 0)
-}
-
-func (s *Stream) timeoutObserver(ch chan struct{}, timedOut func() bool) func() {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-	loop:
-		for {
-			select {
-			case <-ticker.C:
-				if timedOut() {
-					close(ch)
-					break loop
-				}
-			case <-ctx.Done():
-				break loop
-			}
-		}
-		ticker.Stop()
-	}()
-
-	return cancel
-}
@@ -0,0 +1,94 @@
+package yamux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConsumedModeMultiWindowTransfer exercises a transfer several times
+// larger than a single stream window under consumed-mode flow control,
+// guarding against recvWindow underflowing to zero (and the session
+// tearing itself down with ErrRecvWindowExceeded) once readData stops
+// gating on it for that mode.
+func TestConsumedModeMultiWindowTransfer(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+
+	conf := DefaultConfig()
+	conf.EnableKeepAlive = false
+	conf.EnableConsumedWindowUpdates = true
+
+	client, err := Client(conn1, conf)
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	defer client.Close()
+
+	server, err := Server(conn2, conf)
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	defer server.Close()
+
+	const size = 4 * 1024 * 1024 // several multiples of initialStreamWindow
+	payload := bytes.Repeat([]byte{0xAB}, size)
+
+	errCh := make(chan error, 1)
+	go func() {
+		stream, err := client.OpenStream()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer stream.Close()
+		_, err = stream.Write(payload)
+		errCh <- err
+	}()
+
+	stream, err := server.AcceptStream()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer stream.Close()
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("payload mismatch: got %d bytes, want %d", len(got), len(payload))
+	}
+}
+
+// TestSendCreditPreActivationWrite guards against sendCredit underflowing
+// when bytes written before consumed-mode activates are later reported as
+// consumed by the peer: bytesWritten must include them, or the subtraction
+// against peerConsumed wraps around a uint32 and the stream is left with
+// no credit at all.
+func TestSendCreditPreActivationWrite(t *testing.T) {
+	s := newStream(nil, 1, streamInit)
+
+	// A write before the SYN/ACK handshake confirms consumed-mode.
+	s.consumeSendCredit(1024)
+
+	// The peer's ACK arrives and activates consumed-mode on our side.
+	atomic.StoreInt32(&s.consumedActive, 1)
+
+	// The peer now reports having consumed those same pre-activation
+	// bytes, plus its advertised window.
+	s.peerLock.Lock()
+	s.peerConsumed = 1024
+	s.peerWindow = initialStreamWindow
+	s.peerLock.Unlock()
+
+	if got := s.sendCredit(); got != initialStreamWindow {
+		t.Fatalf("sendCredit = %d, want %d (no pre-activation bytes should be unaccounted for)", got, initialStreamWindow)
+	}
+}